@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fatih/color"
+	"github.com/miekg/dns"
+)
+
+// nsAnswer is the result of querying a single nameserver for a domain,
+// used by the parallel-best resolver to build a consensusResult.
+type nsAnswer struct {
+	nsAddr   string
+	nsName   string
+	provider string
+	answer   *dns.Msg
+	err      error
+	wildcard bool
+}
+
+// consensusEntry pairs a nameserver with the answer signature it returned.
+type consensusEntry struct {
+	provider string
+	nsName   string
+	nsAddr   string
+	answer   string
+	wildcard bool
+}
+
+// consensusResult holds the outcome of fanning a single (domain, qtype)
+// query out to every nameserver: the majority answer (consensus), any
+// nameservers whose answer disagreed with it (divergent), and any that
+// failed outright (errored) - kept separate from divergent so a takeover
+// candidate can't be confused with a nameserver that merely timed out.
+type consensusResult struct {
+	domain          string
+	qtype           string
+	consensusAnswer string
+	consensus       []consensusEntry
+	divergent       []consensusEntry
+	errored         []consensusEntry
+}
+
+// answerSignature reduces a successful response to a comparable string so
+// that answers from different nameservers can be grouped and diffed.
+// Transport errors are handled separately by buildConsensus and never reach
+// here, since the remote address embedded in their error text would make
+// every erroring nameserver its own singleton "divergent" group.
+func answerSignature(resp *dns.Msg) string {
+	if resp.Rcode != dns.RcodeSuccess {
+		return dns.RcodeToString[resp.Rcode]
+	}
+
+	if len(resp.Answer) == 0 {
+		return "NODATA"
+	}
+
+	return rrSetSignature(resp.Answer)
+}
+
+// formatAnswer renders the record value carried by an answer RR, ignoring
+// owner name and TTL so that otherwise-identical answers compare equal.
+func formatAnswer(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	case *dns.CNAME:
+		return v.Target
+	default:
+		return rr.String()
+	}
+}
+
+// queryAllNameservers fires the same (domain, qtype) query against every
+// resolved nameserver concurrently and waits for all of them to answer.
+// When wildcardFP is non-nil, each answer is checked against the wildcard
+// fingerprint recorded for (domain, nsAddr, qtype).
+func queryAllNameservers(domain string, mappedNameservers map[string]Nameserver, nsProviders map[string]string, qtype uint16, opts transportOptions, limiter *queryLimiter, stats *statsRegistry, maxRetries int, wildcardFP wildcardFingerprints) []nsAnswer {
+	var wg sync.WaitGroup
+	results := make(chan nsAnswer, len(mappedNameservers))
+
+	for nsAddr, ns := range mappedNameservers {
+		if nsAddr == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(nsAddr string, ns Nameserver) {
+			defer wg.Done()
+			resp, err := queryWithRetry(domain, ns, qtype, opts, limiter, stats, maxRetries)
+			results <- nsAnswer{
+				nsAddr:   nsAddr,
+				nsName:   ns.Spec,
+				provider: nsProviders[nsAddr],
+				answer:   resp,
+				err:      err,
+				wildcard: wildcardFP.isWildcardMatchMsg(domain, nsAddr, qtype, resp, err),
+			}
+		}(nsAddr, ns)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	answers := make([]nsAnswer, 0, len(mappedNameservers))
+	for a := range results {
+		answers = append(answers, a)
+	}
+
+	return answers
+}
+
+// buildConsensus groups a domain's per-nameserver answers by signature and
+// splits them into the majority (consensus) group and everything else
+// (divergent) - the takeover candidates this mode exists to surface.
+// Nameservers that errored outright (timeout, or a transport failure
+// queryWithRetry gave up on) are kept in errored instead, since bucketing
+// them into divergent by their address-specific error text would make every
+// erroring nameserver its own false "divergent" singleton. When
+// hideWildcards is set, answers matching that nameserver's wildcard
+// fingerprint are dropped before grouping, since a sinkhole response on one
+// provider is a common source of false divergence.
+func buildConsensus(domain string, qtype uint16, answers []nsAnswer, hideWildcards bool) consensusResult {
+	groups := make(map[string][]consensusEntry)
+	var errored []consensusEntry
+
+	for _, a := range answers {
+		if hideWildcards && a.wildcard {
+			continue
+		}
+
+		if a.err != nil {
+			errored = append(errored, consensusEntry{
+				provider: a.provider,
+				nsName:   a.nsName,
+				nsAddr:   a.nsAddr,
+				answer:   a.err.Error(),
+			})
+			continue
+		}
+
+		sig := answerSignature(a.answer)
+		groups[sig] = append(groups[sig], consensusEntry{
+			provider: a.provider,
+			nsName:   a.nsName,
+			nsAddr:   a.nsAddr,
+			answer:   sig,
+			wildcard: a.wildcard,
+		})
+	}
+
+	majoritySig, majorityEntries := pickMajority(groups)
+
+	var divergent []consensusEntry
+	for sig, entries := range groups {
+		if sig == majoritySig {
+			continue
+		}
+		divergent = append(divergent, entries...)
+	}
+
+	return consensusResult{
+		domain:          domain,
+		qtype:           dns.TypeToString[qtype],
+		consensusAnswer: majoritySig,
+		consensus:       majorityEntries,
+		divergent:       divergent,
+		errored:         errored,
+	}
+}
+
+// pickMajority picks the largest group in groups, breaking ties
+// deterministically (by distinct-provider count, then lexicographically on
+// the signature) so that a tied split reports the same consensus/divergent
+// split every run against identical DNS state, regardless of Go's randomized
+// map iteration order.
+func pickMajority(groups map[string][]consensusEntry) (string, []consensusEntry) {
+	var majoritySig string
+	var majorityEntries []consensusEntry
+
+	for sig, entries := range groups {
+		switch {
+		case majorityEntries == nil:
+		case len(entries) > len(majorityEntries):
+		case len(entries) < len(majorityEntries):
+			continue
+		case distinctProviders(entries) > distinctProviders(majorityEntries):
+		case distinctProviders(entries) < distinctProviders(majorityEntries):
+			continue
+		case sig < majoritySig:
+		default:
+			continue
+		}
+		majoritySig, majorityEntries = sig, entries
+	}
+
+	return majoritySig, majorityEntries
+}
+
+// distinctProviders counts the distinct cloud providers represented in
+// entries, used to break majority ties in favor of the group corroborated by
+// more independent providers.
+func distinctProviders(entries []consensusEntry) int {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		seen[e.provider] = true
+	}
+	return len(seen)
+}
+
+// printConsensusResult reports a domain only when its nameservers disagreed
+// or failed outright; domains where everyone returned the same answer are
+// not takeover candidates. Errored nameservers are listed in their own
+// section rather than folded into divergent, since a timeout isn't a
+// mismatched answer.
+func printConsensusResult(result consensusResult, fHandle *os.File, red *color.Color) {
+	if len(result.divergent) == 0 && len(result.errored) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[divergence] %s (%s)\n", result.domain, result.qtype)
+
+	if len(result.consensus) > 0 {
+		fmt.Fprintf(&b, "  consensus (%s): %s\n", result.consensusAnswer, joinConsensusEntries(result.consensus))
+	}
+
+	for _, entry := range result.divergent {
+		wildcardTag := ""
+		if entry.wildcard {
+			wildcardTag = " [wildcard]"
+		}
+		fmt.Fprintf(&b, "  divergent [%s] %s (%s): %s%s\n", entry.provider, entry.nsName, entry.nsAddr, entry.answer, wildcardTag)
+	}
+
+	for _, entry := range result.errored {
+		fmt.Fprintf(&b, "  errored [%s] %s (%s): %s\n", entry.provider, entry.nsName, entry.nsAddr, entry.answer)
+	}
+
+	outputStr := b.String()
+	if fHandle != nil {
+		fmt.Fprint(fHandle, outputStr)
+	}
+	red.Print(outputStr)
+}
+
+func joinConsensusEntries(entries []consensusEntry) string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, fmt.Sprintf("%s (%s)", e.nsName, e.nsAddr))
+	}
+	return strings.Join(names, ", ")
+}
+
+// consensusJob is a single (domain, record type) to fan out to every
+// nameserver.
+type consensusJob struct {
+	domain string
+	qtype  uint16
+}
+
+// runConsensusMode is the parallel-best resolver pipeline: the fan-out key is
+// (domain, qtype) rather than domain|nsAddr, and the aggregator waits for
+// every nameserver to answer a (domain, qtype) pair before emitting its
+// consensusResult. qtypes honors -t/--types instead of hardcoding dns.TypeA,
+// matching the progress total main() already computes from it.
+// wildcardFP/hideWildcards apply the same wildcard-detection machinery
+// runStandardMode uses, since a sinkhole response on a single provider is a
+// prime source of false "divergent" hits here.
+func runConsensusMode(domainsList []string, mappedNameservers map[string]Nameserver, nsProviders map[string]string, qtypes []uint16, numWorkers int, fHandle *os.File, red *color.Color, opts transportOptions, limiter *queryLimiter, stats *statsRegistry, maxRetries int, wildcardFP wildcardFingerprints, hideWildcards bool) {
+	var wg sync.WaitGroup
+	var wg2 sync.WaitGroup
+
+	pendingJobs := make(chan consensusJob)
+	consensusResults := make(chan consensusResult)
+
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		for result := range consensusResults {
+			printConsensusResult(result, fHandle, red)
+		}
+	}()
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range pendingJobs {
+				answers := queryAllNameservers(job.domain, mappedNameservers, nsProviders, job.qtype, opts, limiter, stats, maxRetries, wildcardFP)
+				atomic.AddInt32(&progress, int32(len(answers)))
+				consensusResults <- buildConsensus(job.domain, job.qtype, answers, hideWildcards)
+			}
+		}()
+	}
+
+	for _, domain := range domainsList {
+		for _, qtype := range qtypes {
+			pendingJobs <- consensusJob{domain: domain, qtype: qtype}
+		}
+	}
+	close(pendingJobs)
+
+	wg.Wait()
+	close(consensusResults)
+	wg2.Wait()
+}
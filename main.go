@@ -3,12 +3,13 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/pflag"
@@ -26,24 +27,6 @@ var validProviders = map[string][]string{
 var progress int32
 var total int32
 
-func queryDNS(domain string, nameserver string) (*dns.Msg, error) {
-	c := new(dns.Client)
-	c.Timeout = 5 * time.Second
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-
-	r, _, err := c.Exchange(m, nameserver)
-	if err != nil {
-		return nil, err
-	}
-
-	if r.Rcode != dns.RcodeSuccess {
-		return nil, fmt.Errorf("No answer from nameserver: %s", nameserver)
-	}
-
-	return r, nil
-}
-
 func main() {
 	banner := `                 _
                 (_)
@@ -64,6 +47,20 @@ func main() {
 	var quietMode bool
 	var verboseMode bool
 	var outputFile string
+	var consensusMode bool
+	var tlsServerName string
+	var insecureSkipVerify bool
+	var http1Mode bool
+	var typesFlag string
+	var followCNAME bool
+	var maxCNAMEDepth int
+	var outputFormat string
+	var globalQPS float64
+	var perNSQPS float64
+	var maxRetries int
+	var detectWildcardsMode bool
+	var wildcardProbes int
+	var hideWildcards bool
 
 	green := color.New(color.FgGreen)
 	yellow := color.New(color.FgYellow)
@@ -76,12 +73,40 @@ func main() {
 	pflag.BoolVarP(&quietMode, "quiet", "q", false, "Only output raw results")
 	pflag.BoolVarP(&verboseMode, "verbose", "v", false, "Verbose mode")
 	pflag.StringVarP(&outputFile, "output", "o", "", "Output file where to save results")
+	pflag.BoolVar(&consensusMode, "consensus", false, "Parallel-best mode: query every nameserver per domain concurrently and report ones that diverge")
+	pflag.StringVar(&tlsServerName, "tls-server-name", "", "Override the TLS server name used for tls:// and https:// nameservers")
+	pflag.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Skip TLS certificate verification for tls:// and https:// nameservers")
+	pflag.BoolVar(&http1Mode, "http1", false, "Use HTTP/1.1 instead of HTTP/2 for https:// (DoH) nameservers")
+	pflag.StringVarP(&typesFlag, "types", "t", "A", "Comma-separated record types to query (A,AAAA,CNAME,NS,TXT,MX,SOA,CAA,...)")
+	pflag.BoolVar(&followCNAME, "follow-cname", false, "Follow CNAME chains to a terminal answer, NXDOMAIN or loop")
+	pflag.IntVar(&maxCNAMEDepth, "max-cname-depth", 10, "Maximum CNAME hops to follow with --follow-cname")
+	pflag.StringVar(&outputFormat, "output-format", "text", "Result format: text, jsonl, json or csv")
+	pflag.Float64Var(&globalQPS, "qps", 0, "Global queries-per-second limit across all nameservers (0 = unlimited)")
+	pflag.Float64Var(&perNSQPS, "qps-per-ns", 0, "Per-nameserver queries-per-second limit (0 = unlimited)")
+	pflag.IntVar(&maxRetries, "max-retries", 2, "Max retries for timeouts, SERVFAIL and REFUSED, with exponential backoff")
+	pflag.BoolVar(&detectWildcardsMode, "detect-wildcards", false, "Probe each nameserver with randomized non-existent subdomains per domain and tag results matching the wildcard/catch-all answer")
+	pflag.IntVar(&wildcardProbes, "wildcard-probes", 3, "Number of randomized probes per (domain, nameserver, type) used for wildcard detection")
+	pflag.BoolVar(&hideWildcards, "hide-wildcards", false, "Suppress results tagged as wildcard/catch-all matches instead of just tagging them")
 
 	pflag.Parse()
 
+	if consensusMode && outputFormat != "" && outputFormat != "text" {
+		log.Fatalf("--consensus only supports --output-format text; consensus results are not wired into the structured sinks")
+	}
+
+	// When a structured format is going to stdout (no -o given), the banner
+	// and status lines below must not share that stream, or they corrupt the
+	// jsonl/json/csv output. Route them to stderr in that case instead of
+	// relying on the caller to also pass -q.
+	structuredToStdout := outputFormat != "" && outputFormat != "text" && outputFile == ""
+	statusOut := io.Writer(os.Stdout)
+	if structuredToStdout {
+		statusOut = os.Stderr
+	}
+
 	if !quietMode {
-		fmt.Println(banner)
-		fmt.Printf("[v%s]\n\n", version)
+		fmt.Fprintln(statusOut, banner)
+		fmt.Fprintf(statusOut, "[v%s]\n\n", version)
 	}
 
 	providerLists, ok := validProviders[cloudProvider]
@@ -93,6 +118,12 @@ func main() {
 		log.Fatalf("Invalid number of workers: %d. It must be a positive integer.", numWorkers)
 	}
 
+	qtypes, err := parseRecordTypes(typesFlag)
+	if err != nil {
+		log.Fatalf("Invalid -t/--types value: %v", err)
+	}
+
+	nsProviderOf := make(map[string]string)
 	for _, filename := range providerLists {
 		file, err := os.Open(filename)
 		if err != nil {
@@ -100,10 +131,13 @@ func main() {
 		}
 		defer file.Close()
 
+		providerName := strings.TrimSuffix(filepath.Base(filename), ".txt")
+
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
 			line := scanner.Text()
 			nameservers = append(nameservers, line)
+			nsProviderOf[line] = providerName
 		}
 
 		if err := scanner.Err(); err != nil {
@@ -138,15 +172,18 @@ func main() {
 
 	numNameservers := len(nameservers)
 	numDomains := len(domainsList)
-	sampleSize := numDomains * numNameservers
+	sampleSize := numDomains * numNameservers * len(qtypes)
 
 	if !quietMode {
-		fmt.Printf("[+] %d domains x %d nameservers = %d queries\n", numDomains, numNameservers, sampleSize)
-		fmt.Printf("[+] Workers: %d\n", numWorkers)
-		fmt.Printf("[~] Mapping IPs for nameservers\n")
-		fmt.Printf("[~] Press enter at any time to check the progress\n")
+		fmt.Fprintf(statusOut, "[+] %d domains x %d nameservers x %d types = %d queries\n", numDomains, numNameservers, len(qtypes), sampleSize)
+		fmt.Fprintf(statusOut, "[+] Workers: %d\n", numWorkers)
+		fmt.Fprintf(statusOut, "[~] Mapping IPs for nameservers\n")
+		fmt.Fprintf(statusOut, "[~] Press enter at any time to check the progress\n")
 	}
 
+	limiter := newQueryLimiter(globalQPS, perNSQPS)
+	stats := newStatsRegistry()
+
 	go func() {
 		reader := bufio.NewReader(os.Stdin)
 
@@ -158,37 +195,49 @@ func main() {
 
 			val1 := atomic.LoadInt32(&progress)
 			val2 := atomic.LoadInt32(&total)
+			attempts, retries, failures := stats.snapshot()
 			log.Printf(
-				"[~] Progress: %d/%d (%.2f%%)\n",
+				"[~] Progress: %d/%d (%.2f%%) | attempts: %d, retries: %d, failures: %d\n",
 				val1, val2,
 				float64(val1)*100/float64(val2),
+				attempts, retries, failures,
 			)
 		}
 	}()
 
 	mappedNameservers := resolveNameservers(nameservers, numWorkers)
 
-	if !quietMode {
-		fmt.Printf("[~] Querying domains against nameservers\n")
+	nsProviders := make(map[string]string)
+	for nsAddr, ns := range mappedNameservers {
+		if provider, ok := nsProviderOf[ns.Spec]; ok {
+			nsProviders[nsAddr] = provider
+		} else {
+			nsProviders[nsAddr] = "custom"
+		}
 	}
 
-	atomic.StoreInt32(&progress, int32(0))
-	atomic.StoreInt32(&total, int32(sampleSize))
+	opts := transportOptions{
+		TLSServerName:      tlsServerName,
+		InsecureSkipVerify: insecureSkipVerify,
+		HTTP1:              http1Mode,
+	}
 
-	type wrappedAnswer struct {
-		nsIP   string
-		domain string
-		answer *dns.Msg
+	var wildcardFP wildcardFingerprints
+	if detectWildcardsMode {
+		if !quietMode {
+			fmt.Fprintf(statusOut, "[~] Probing nameservers for wildcard/catch-all responses\n")
+		}
+		wildcardFP = detectWildcards(domainsList, mappedNameservers, qtypes, wildcardProbes, followCNAME, maxCNAMEDepth, opts, limiter, stats, maxRetries, numWorkers)
 	}
 
-	var wg sync.WaitGroup
-	var wg2 sync.WaitGroup
+	if !quietMode {
+		fmt.Fprintf(statusOut, "[~] Querying domains against nameservers\n")
+	}
 
-	pendingQueries := make(chan string)      // Input
-	queryAnswers := make(chan wrappedAnswer) // Output
+	atomic.StoreInt32(&progress, int32(0))
+	atomic.StoreInt32(&total, int32(sampleSize))
 
 	var fHandle *os.File
-	var err error
 	if outputFile != "" {
 		fHandle, err = os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 		if err != nil {
@@ -196,46 +245,69 @@ func main() {
 		}
 	}
 
+	var sinkOut io.Writer
+	if fHandle != nil {
+		sinkOut = fHandle
+	} else if outputFormat != "" && outputFormat != "text" {
+		sinkOut = os.Stdout
+	}
+
+	if consensusMode {
+		runConsensusMode(domainsList, mappedNameservers, nsProviders, qtypes, numWorkers, fHandle, red, opts, limiter, stats, maxRetries, wildcardFP, hideWildcards)
+		return
+	}
+
+	sink, err := newResultSink(outputFormat, sinkOut, verboseMode, green, yellow, red)
+	if err != nil {
+		log.Fatalf("Invalid --output-format value: %v", err)
+	}
+
+	runStandardMode(domainsList, mappedNameservers, qtypes, numWorkers, followCNAME, maxCNAMEDepth, sink, opts, limiter, stats, maxRetries, wildcardFP, hideWildcards)
+
+	sink.Close()
+}
+
+// queryJob is a single (domain, nameserver, record type) unit of work.
+type queryJob struct {
+	domain string
+	nsAddr string
+	qtype  uint16
+}
+
+// runStandardMode is the original per-(domain, nameserver) pipeline, now
+// fanned out over every requested record type too and, with --follow-cname,
+// walking each domain's alias chain to its terminal answer. Results are
+// handed to a resultSink rather than printed directly, so --output-format
+// can swap the destination without touching the query pipeline. When
+// wildcardFP is non-nil, results matching a (domain, nameserver, type)'s
+// wildcard fingerprint are tagged, and dropped entirely if hideWildcards is
+// set.
+func runStandardMode(domainsList []string, mappedNameservers map[string]Nameserver, qtypes []uint16, numWorkers int, followCNAME bool, maxCNAMEDepth int, sink resultSink, opts transportOptions, limiter *queryLimiter, stats *statsRegistry, maxRetries int, wildcardFP wildcardFingerprints, hideWildcards bool) {
+	type wrappedAnswer struct {
+		domain string
+		nsAddr string
+		qtype  uint16
+		result chainResult
+	}
+
+	var wg sync.WaitGroup
+	var wg2 sync.WaitGroup
+
+	pendingQueries := make(chan queryJob)    // Input
+	queryAnswers := make(chan wrappedAnswer) // Output
+
 	// Show results as they arrive
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
 		for result := range queryAnswers {
-			var outputStr string
-
-			domain := result.domain
-			nsIP := result.nsIP
-			nsName := mappedNameservers[nsIP]
-			resp := result.answer
-			if len(resp.Answer) > 0 {
-				for _, answer := range resp.Answer {
-					if aRecord, ok := answer.(*dns.A); ok {
-						outputStr = fmt.Sprintf("[%s (%s)] %s => %s\n", nsName, nsIP, domain, aRecord.A)
-						if fHandle != nil {
-							fmt.Fprintf(fHandle, outputStr)
-						}
-						green.Printf(outputStr)
-					} else if cnameRecord, ok := answer.(*dns.CNAME); ok {
-						outputStr = fmt.Sprintf("[%s (%s)] %s => %s\n", nsName, nsIP, domain, cnameRecord.Target)
-						if fHandle != nil {
-							fmt.Fprintf(fHandle, outputStr)
-						}
-						green.Printf(outputStr)
-					} else if aaaaRecord, ok := answer.(*dns.AAAA); ok {
-						outputStr = fmt.Sprintf("[%s (%s)] %s => %s\n", nsName, nsIP, domain, aaaaRecord.AAAA)
-						if fHandle != nil {
-							fmt.Fprintf(fHandle, outputStr)
-						}
-						green.Printf(outputStr)
-					} else {
-						outputStr = fmt.Sprintf("[%s (%s)] %s\n", nsName, nsIP, domain, answer)
-						if fHandle != nil {
-							fmt.Fprintf(fHandle, outputStr)
-						}
-						yellow.Printf(outputStr)
-					}
-				}
+			nsName := mappedNameservers[result.nsAddr].Spec
+			rec := buildOutputRecord(result.domain, nsName, result.nsAddr, dns.TypeToString[result.qtype], result.result)
+			rec.Wildcard = wildcardFP.isWildcardMatch(result.domain, result.nsAddr, result.qtype, result.result)
+			if rec.Wildcard && hideWildcards {
+				continue
 			}
+			sink.Write(rec)
 		}
 	}()
 
@@ -246,42 +318,31 @@ func main() {
 		go func() {
 			defer wg.Done()
 
-			for query := range pendingQueries {
-				parts := strings.Split(query, "|")
-				if len(parts) != 2 {
-					continue
-				}
-
-				domain, nsIP := parts[0], parts[1]
-
-				resp, err := queryDNS(domain, nsIP+":53")
+			for job := range pendingQueries {
+				ns := mappedNameservers[job.nsAddr]
+				result := resolveChain(job.domain, ns, job.qtype, opts, followCNAME, maxCNAMEDepth, limiter, stats, maxRetries)
 				atomic.AddInt32(&progress, int32(1))
 
-				if err != nil {
-					if verboseMode {
-						red.Printf(fmt.Sprintf("[-] %s\n", err))
-					}
-					continue
-				}
-
 				queryAnswers <- wrappedAnswer{
-					nsIP,
-					domain,
-					resp,
+					domain: job.domain,
+					nsAddr: job.nsAddr,
+					qtype:  job.qtype,
+					result: result,
 				}
 			}
 		}()
 	}
 
 	// Dispatch queries to be run by the workers
-	for nsIP, _ := range mappedNameservers {
-		if nsIP == "" {
+	for nsAddr := range mappedNameservers {
+		if nsAddr == "" {
 			continue
 		}
 
 		for _, domain := range domainsList {
-			query := fmt.Sprintf("%s|%s", domain, nsIP)
-			pendingQueries <- query
+			for _, qtype := range qtypes {
+				pendingQueries <- queryJob{domain: domain, nsAddr: nsAddr, qtype: qtype}
+			}
 		}
 	}
 
@@ -3,38 +3,111 @@ package main
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"strings"
 	"sync"
 )
 
-func resolveNameserver(ns string) (string, error) {
-	ips, err := net.LookupIP(ns)
+// transport identifies how a nameserver should be queried.
+type transport int
+
+const (
+	transportUDP transport = iota
+	transportTCP
+	transportTLS
+	transportDoH
+)
+
+// Nameserver is a resolved query target: the transport to use plus the
+// address to dial (host:port for udp/tcp/tls, a full URL for DoH) and the
+// original spec it was parsed from, kept around for display.
+type Nameserver struct {
+	Spec      string
+	Transport transport
+	Address   string
+}
+
+// parseNameserverSpec splits a `-n`/`--nameservers` entry into its transport
+// and address, defaulting to plain UDP when no scheme is given.
+func parseNameserverSpec(spec string) (transport, string, error) {
+	if !strings.Contains(spec, "://") {
+		return transportUDP, spec, nil
+	}
+
+	u, err := url.Parse(spec)
 	if err != nil {
-		return "", fmt.Errorf("Failed to resolve nameserver: %v", err)
+		return transportUDP, "", fmt.Errorf("Invalid nameserver spec %q: %v", spec, err)
 	}
-	if len(ips) == 0 {
-		return "", fmt.Errorf("No IP addresses found for nameserver: %s", ns)
+
+	switch u.Scheme {
+	case "udp":
+		return transportUDP, u.Host, nil
+	case "tcp":
+		return transportTCP, u.Host, nil
+	case "tls":
+		return transportTLS, u.Host, nil
+	case "https":
+		return transportDoH, spec, nil
+	default:
+		return transportUDP, "", fmt.Errorf("Unsupported transport scheme: %s://", u.Scheme)
+	}
+}
+
+// resolveNameserver turns a nameserver spec into a dialable Nameserver. DoH
+// specs are left as-is since the URL host is resolved by the HTTP client;
+// everything else gets its hostname resolved to an IP up front.
+func resolveNameserver(spec string) (Nameserver, error) {
+	t, address, err := parseNameserverSpec(spec)
+	if err != nil {
+		return Nameserver{}, err
 	}
-	return ips[0].String(), nil
+
+	if t == transportDoH {
+		return Nameserver{Spec: spec, Transport: t, Address: address}, nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		if t == transportTLS {
+			port = "853"
+		} else {
+			port = "53"
+		}
+	}
+
+	if net.ParseIP(host) == nil {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return Nameserver{}, fmt.Errorf("Failed to resolve nameserver: %v", err)
+		}
+		if len(ips) == 0 {
+			return Nameserver{}, fmt.Errorf("No IP addresses found for nameserver: %s", host)
+		}
+		host = ips[0].String()
+	}
+
+	return Nameserver{Spec: spec, Transport: t, Address: net.JoinHostPort(host, port)}, nil
 }
 
-func worker(id int, jobs <-chan string, results chan<- map[string]string, wg *sync.WaitGroup) {
+func worker(id int, jobs <-chan string, results chan<- map[string]Nameserver, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for ns := range jobs {
-		ip, err := resolveNameserver(ns)
+	for spec := range jobs {
+		ns, err := resolveNameserver(spec)
 		if err != nil {
-			results <- map[string]string{ns: ""}
+			results <- map[string]Nameserver{spec: {}}
 		} else {
-			results <- map[string]string{ns: ip}
+			results <- map[string]Nameserver{spec: ns}
 		}
 	}
 }
 
-func resolveNameservers(nameservers []string, numWorkers int) map[string]string {
-	resultsMap := make(map[string]string)
+func resolveNameservers(nameservers []string, numWorkers int) map[string]Nameserver {
+	resultsMap := make(map[string]Nameserver)
 
 	jobs := make(chan string, len(nameservers))
-	results := make(chan map[string]string, len(nameservers))
+	results := make(chan map[string]Nameserver, len(nameservers))
 
 	var wg sync.WaitGroup
 
@@ -54,8 +127,11 @@ func resolveNameservers(nameservers []string, numWorkers int) map[string]string
 	}()
 
 	for result := range results {
-		for ns, ip := range result {
-			resultsMap[ip] = ns
+		for _, ns := range result {
+			if ns.Address == "" {
+				continue
+			}
+			resultsMap[ns.Address] = ns
 		}
 	}
 
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/miekg/dns"
+)
+
+// answerRecord is one RR in an outputRecord's Answers list.
+type answerRecord struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+// outputRecord is the stable, sink-agnostic shape of a single query result.
+type outputRecord struct {
+	Timestamp      string         `json:"timestamp"`
+	Domain         string         `json:"domain"`
+	NameserverName string         `json:"nameserver_name"`
+	NameserverIP   string         `json:"nameserver_ip"`
+	QueryType      string         `json:"query_type"`
+	Rcode          string         `json:"rcode"`
+	Answers        []answerRecord `json:"answers"`
+	Error          string         `json:"error,omitempty"`
+	Chain          []string       `json:"chain,omitempty"`
+	Wildcard       bool           `json:"wildcard,omitempty"`
+}
+
+// buildOutputRecord flattens a chainResult (plus the nameserver/query
+// context it was resolved under) into the stable schema every sink shares.
+func buildOutputRecord(domain, nsName, nsAddr, qtypeName string, result chainResult) outputRecord {
+	rec := outputRecord{
+		Timestamp:      time.Now().UTC().Format(time.RFC3339Nano),
+		Domain:         domain,
+		NameserverName: nsName,
+		NameserverIP:   nsAddr,
+		QueryType:      qtypeName,
+		Rcode:          result.Rcode,
+	}
+
+	if len(result.Chain) > 1 {
+		rec.Chain = result.Chain
+	}
+
+	if result.Err != nil {
+		rec.Error = result.Err.Error()
+		return rec
+	}
+
+	if result.Rcode == "" {
+		rec.Rcode = "NXDOMAIN"
+	}
+
+	if result.Answer != nil {
+		for _, rr := range result.Answer.Answer {
+			rec.Answers = append(rec.Answers, answerRecord{
+				Type:  dns.TypeToString[rr.Header().Rrtype],
+				Value: formatAnswer(rr),
+				TTL:   rr.Header().Ttl,
+			})
+		}
+	}
+
+	return rec
+}
+
+// resultSink is a pluggable output destination for query results - the
+// human-colored terminal view is just one implementation alongside the
+// structured ones selected with --output-format.
+type resultSink interface {
+	Write(rec outputRecord)
+	Close()
+}
+
+// newResultSink builds the sink requested by --output-format. out is where
+// structured formats write to; the text sink writes both to out (if not
+// nil) and to the terminal in color, same as before this flag existed.
+func newResultSink(format string, out io.Writer, verboseMode bool, green, yellow, red *color.Color) (resultSink, error) {
+	switch format {
+	case "", "text":
+		return &textSink{out: out, verboseMode: verboseMode, green: green, yellow: yellow, red: red}, nil
+	case "jsonl":
+		return &jsonlSink{out: out}, nil
+	case "json":
+		return &jsonSink{out: out}, nil
+	case "csv":
+		return newCSVSink(out), nil
+	default:
+		return nil, fmt.Errorf("Unknown output format: %s", format)
+	}
+}
+
+// textSink reproduces the tool's original colored, human-oriented output.
+type textSink struct {
+	out                io.Writer
+	verboseMode        bool
+	green, yellow, red *color.Color
+}
+
+func (s *textSink) Write(rec outputRecord) {
+	chained := len(rec.Chain) > 0
+	chainStr := rec.Domain
+	if chained {
+		chainStr = strings.Join(rec.Chain, " -> ")
+	}
+
+	if len(rec.Answers) == 0 {
+		terminal := rec.Error
+		if terminal == "" {
+			terminal = rec.Rcode
+		}
+
+		if !chained && !s.verboseMode {
+			return
+		}
+
+		s.print(s.red, fmt.Sprintf("[%s (%s)] %s %s -> %s\n", rec.NameserverName, rec.NameserverIP, rec.QueryType, chainStr, terminal))
+		return
+	}
+
+	for _, answer := range rec.Answers {
+		c := s.yellow
+		switch answer.Type {
+		case "A", "AAAA", "CNAME":
+			c = s.green
+		}
+
+		wildcardTag := ""
+		if rec.Wildcard {
+			wildcardTag = " [wildcard]"
+			c = s.yellow
+		}
+
+		s.print(c, fmt.Sprintf("[%s (%s)] %s %s => %s%s\n", rec.NameserverName, rec.NameserverIP, rec.QueryType, chainStr, answer.Value, wildcardTag))
+	}
+}
+
+func (s *textSink) print(c *color.Color, line string) {
+	if s.out != nil {
+		fmt.Fprint(s.out, line)
+	}
+	c.Print(line)
+}
+
+func (s *textSink) Close() {}
+
+// jsonlSink streams one JSON object per line as results complete.
+type jsonlSink struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func (s *jsonlSink) Write(rec outputRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(s.out, string(data))
+}
+
+func (s *jsonlSink) Close() {}
+
+// jsonSink buffers every record and writes a single JSON array at Close,
+// since a valid JSON document can't be streamed incrementally.
+type jsonSink struct {
+	out     io.Writer
+	mu      sync.Mutex
+	records []outputRecord
+}
+
+func (s *jsonSink) Write(rec outputRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+}
+
+func (s *jsonSink) Close() {
+	if s.records == nil {
+		s.records = []outputRecord{}
+	}
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(s.out, string(data))
+}
+
+// csvSink writes one row per (domain, nameserver, query type, answer)
+// tuple, with a row of empty Answers columns when there were none.
+type csvSink struct {
+	w  *csv.Writer
+	mu sync.Mutex
+}
+
+func newCSVSink(out io.Writer) *csvSink {
+	w := csv.NewWriter(out)
+	w.Write([]string{"timestamp", "domain", "nameserver_name", "nameserver_ip", "query_type", "rcode", "answer_type", "answer_value", "answer_ttl", "error", "chain", "wildcard"})
+	return &csvSink{w: w}
+}
+
+func (s *csvSink) Write(rec outputRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chain := strings.Join(rec.Chain, " -> ")
+	wildcard := strconv.FormatBool(rec.Wildcard)
+
+	if len(rec.Answers) == 0 {
+		s.w.Write([]string{rec.Timestamp, rec.Domain, rec.NameserverName, rec.NameserverIP, rec.QueryType, rec.Rcode, "", "", "", rec.Error, chain, wildcard})
+	} else {
+		for _, a := range rec.Answers {
+			s.w.Write([]string{rec.Timestamp, rec.Domain, rec.NameserverName, rec.NameserverIP, rec.QueryType, rec.Rcode, a.Type, a.Value, strconv.FormatUint(uint64(a.TTL), 10), rec.Error, chain, wildcard})
+		}
+	}
+	s.w.Flush()
+}
+
+func (s *csvSink) Close() {}
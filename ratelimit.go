@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+)
+
+// queryLimiter gates queries behind an optional global rate.Limiter and a
+// per-nameserver one, created lazily as new nameservers are seen.
+type queryLimiter struct {
+	global   *rate.Limiter
+	perNS    map[string]*rate.Limiter
+	perNSQPS float64
+	mu       sync.Mutex
+}
+
+// newQueryLimiter builds the limiter for --qps/--qps-per-ns. A qps of 0
+// means that limit is disabled.
+func newQueryLimiter(qps float64, qpsPerNS float64) *queryLimiter {
+	l := &queryLimiter{perNS: make(map[string]*rate.Limiter), perNSQPS: qpsPerNS}
+	if qps > 0 {
+		l.global = rate.NewLimiter(rate.Limit(qps), burstFor(qps))
+	}
+	return l
+}
+
+func burstFor(qps float64) int {
+	if qps < 1 {
+		return 1
+	}
+	return int(qps)
+}
+
+// wait blocks until both the global and the nsAddr-specific budget allow
+// another query through.
+func (l *queryLimiter) wait(ctx context.Context, nsAddr string) {
+	if l.global != nil {
+		l.global.Wait(ctx)
+	}
+
+	if l.perNSQPS <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	limiter, ok := l.perNS[nsAddr]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.perNSQPS), burstFor(l.perNSQPS))
+		l.perNS[nsAddr] = limiter
+	}
+	l.mu.Unlock()
+
+	limiter.Wait(ctx)
+}
+
+// nsStats accumulates the per-nameserver retry counters shown in the
+// progress readout.
+type nsStats struct {
+	attempts int64
+	retries  int64
+	failures int64
+}
+
+// statsRegistry hands out an nsStats per nameserver address, creating one on
+// first access.
+type statsRegistry struct {
+	mu   sync.Mutex
+	byNS map[string]*nsStats
+}
+
+func newStatsRegistry() *statsRegistry {
+	return &statsRegistry{byNS: make(map[string]*nsStats)}
+}
+
+func (r *statsRegistry) get(nsAddr string) *nsStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.byNS[nsAddr]
+	if !ok {
+		s = &nsStats{}
+		r.byNS[nsAddr] = s
+	}
+	return s
+}
+
+// snapshot totals every nameserver's counters for the progress readout.
+func (r *statsRegistry) snapshot() (attempts, retries, failures int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.byNS {
+		attempts += atomic.LoadInt64(&s.attempts)
+		retries += atomic.LoadInt64(&s.retries)
+		failures += atomic.LoadInt64(&s.failures)
+	}
+	return
+}
+
+// isRetryable reports whether a failed query is worth retrying: a transport
+// timeout, or a server telling us to back off (SERVFAIL/REFUSED).
+func isRetryable(resp *dns.Msg, err error) bool {
+	if err != nil {
+		return isTimeout(err)
+	}
+	return resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused
+}
+
+func isTimeout(err error) bool {
+	type timeout interface{ Timeout() bool }
+	if t, ok := err.(timeout); ok {
+		return t.Timeout()
+	}
+	return false
+}
+
+// retryBackoff returns the delay before retry attempt `attempt` (0-indexed):
+// exponential growth off a 200ms base, plus up to 50% jitter so a burst of
+// queries that all got SERVFAILed together don't retry in lockstep.
+func retryBackoff(attempt int) time.Duration {
+	base := 200 * time.Millisecond
+	delay := base * time.Duration(uint(1)<<uint(attempt))
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay + jitter
+}
+
+// queryWithRetry wraps queryDNSRaw with rate limiting and exponential
+// backoff retry, recording attempt/retry/permanent-failure counts for ns in
+// stats.
+func queryWithRetry(domain string, ns Nameserver, qtype uint16, opts transportOptions, limiter *queryLimiter, stats *statsRegistry, maxRetries int) (*dns.Msg, error) {
+	s := stats.get(ns.Address)
+	ctx := context.Background()
+
+	var resp *dns.Msg
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		limiter.wait(ctx, ns.Address)
+		atomic.AddInt64(&s.attempts, 1)
+
+		resp, err = queryDNSRaw(domain, ns, qtype, opts)
+		if !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		if attempt >= maxRetries {
+			atomic.AddInt64(&s.failures, 1)
+			return resp, err
+		}
+
+		atomic.AddInt64(&s.retries, 1)
+		time.Sleep(retryBackoff(attempt))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// chainResult captures the alias chain walked while resolving a domain.
+// Chain holds every name queried in order, starting with the original
+// domain; Answer carries the terminal response when one was reached, and
+// Terminal instead describes a dead end (NXDOMAIN, NODATA, a loop, or an
+// error) - the signal this mode exists to surface. Rcode and Err split that
+// same dead end into a DNS-level result versus a transport failure, which
+// output sinks need to fill in a record's "rcode"/"error" fields.
+type chainResult struct {
+	Chain    []string
+	Answer   *dns.Msg
+	Terminal string
+	Rcode    string
+	Err      error
+}
+
+// parseRecordTypes turns a `-t/--types` CSV like "A,AAAA,CNAME" into the
+// dns.Type values queryDNS expects.
+func parseRecordTypes(spec string) ([]uint16, error) {
+	var qtypes []uint16
+
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		qtype, ok := dns.StringToType[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown record type: %s", name)
+		}
+		qtypes = append(qtypes, qtype)
+	}
+
+	if len(qtypes) == 0 {
+		return nil, fmt.Errorf("No record types specified")
+	}
+
+	return qtypes, nil
+}
+
+// resolveChain queries domain for qtype against ns and, when followCNAME is
+// set and the answer is a bare CNAME with no record of the requested type,
+// re-queries the CNAME target instead - up to maxDepth hops, bailing out if
+// a name reappears (an alias loop). This is what lets the tool report
+// dangling chains like "foo.example.com -> bar.cloudfront.net -> NXDOMAIN".
+// Every hop goes through limiter/stats so rate limiting and retry counters
+// apply uniformly, however many names the chain ends up visiting.
+func resolveChain(domain string, ns Nameserver, qtype uint16, opts transportOptions, followCNAME bool, maxDepth int, limiter *queryLimiter, stats *statsRegistry, maxRetries int) chainResult {
+	chain := []string{domain}
+	visited := map[string]bool{dns.Fqdn(domain): true}
+	current := domain
+
+	for depth := 0; ; depth++ {
+		resp, err := queryWithRetry(current, ns, qtype, opts, limiter, stats, maxRetries)
+		if err != nil {
+			return chainResult{Chain: chain, Terminal: err.Error(), Err: err}
+		}
+
+		rcode := dns.RcodeToString[resp.Rcode]
+		if resp.Rcode == dns.RcodeNameError {
+			return chainResult{Chain: chain, Terminal: "NXDOMAIN", Rcode: rcode}
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return chainResult{Chain: chain, Terminal: rcode, Rcode: rcode}
+		}
+
+		cname := cnameTarget(resp)
+		if !followCNAME || hasType(resp, qtype) || cname == "" {
+			if len(resp.Answer) == 0 {
+				return chainResult{Chain: chain, Terminal: "NODATA", Rcode: rcode}
+			}
+			return chainResult{Chain: chain, Answer: resp, Rcode: rcode}
+		}
+
+		if depth >= maxDepth {
+			return chainResult{Chain: chain, Terminal: fmt.Sprintf("CNAME chain too deep (>%d)", maxDepth), Rcode: rcode}
+		}
+
+		if visited[dns.Fqdn(cname)] {
+			return chainResult{Chain: chain, Terminal: "CNAME loop detected", Rcode: rcode}
+		}
+		visited[dns.Fqdn(cname)] = true
+		chain = append(chain, cname)
+		current = cname
+	}
+}
+
+func hasType(resp *dns.Msg, qtype uint16) bool {
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+func cnameTarget(resp *dns.Msg) string {
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			return cname.Target
+		}
+	}
+	return ""
+}
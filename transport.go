@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// transportOptions carries the TLS/HTTP knobs shared by the DoT and DoH
+// query paths, threaded down from the CLI flags.
+type transportOptions struct {
+	TLSServerName      string
+	InsecureSkipVerify bool
+	HTTP1              bool
+}
+
+// queryDNS behaves like the original plain-UDP queryDNS: it resolves the
+// nameserver's transport and returns an error for any non-success Rcode.
+func queryDNS(domain string, ns Nameserver, qtype uint16, opts transportOptions) (*dns.Msg, error) {
+	r, err := queryDNSRaw(domain, ns, qtype, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("No answer from nameserver: %s", ns.Spec)
+	}
+
+	return r, nil
+}
+
+// queryDNSRaw dispatches to the right transport and returns the response
+// regardless of its Rcode, so callers can distinguish NXDOMAIN/SERVFAIL from
+// a transport failure.
+func queryDNSRaw(domain string, ns Nameserver, qtype uint16, opts transportOptions) (*dns.Msg, error) {
+	if ns.Transport == transportDoH {
+		return queryDoH(domain, ns.Address, qtype, opts)
+	}
+	return queryClassic(domain, ns, qtype, opts)
+}
+
+// queryClassic handles plain UDP, TCP and DoT (tcp-tls) via miekg/dns's
+// Client, which is all three of those transports already understand.
+func queryClassic(domain string, ns Nameserver, qtype uint16, opts transportOptions) (*dns.Msg, error) {
+	c := new(dns.Client)
+	c.Timeout = 5 * time.Second
+
+	switch ns.Transport {
+	case transportTCP:
+		c.Net = "tcp"
+	case transportTLS:
+		c.Net = "tcp-tls"
+		c.TLSConfig = &tls.Config{
+			ServerName:         tlsServerName(ns, opts),
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), qtype)
+
+	r, _, err := c.Exchange(m, ns.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// tlsServerName picks the SNI/cert name to validate against for a DoT
+// nameserver: the user-provided override, or else the bare host it dials.
+func tlsServerName(ns Nameserver, opts transportOptions) string {
+	if opts.TLSServerName != "" {
+		return opts.TLSServerName
+	}
+
+	host, _, err := net.SplitHostPort(ns.Address)
+	if err != nil {
+		return ns.Address
+	}
+
+	return host
+}
+
+// queryDoH speaks RFC 8484 DNS-over-HTTPS: it packs the query into a wire
+// format message and POSTs it as application/dns-message.
+func queryDoH(domain string, rawURL string, qtype uint16, opts transportOptions) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), qtype)
+	m.Id = 0
+
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: !opts.HTTP1,
+			TLSClientConfig: &tls.Config{
+				ServerName:         opts.TLSServerName,
+				InsecureSkipVerify: opts.InsecureSkipVerify,
+			},
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH request to %s failed: %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
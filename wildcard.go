@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// wildcardKey identifies a (target domain, nameserver, record type) probed
+// for a catch-all answer.
+type wildcardKey struct {
+	domain string
+	nsAddr string
+	qtype  uint16
+}
+
+// wildcardFingerprints maps a wildcardKey to the set of answer signatures
+// seen when probing it with randomized non-existent subdomains. A live scan
+// result whose signature appears in this set is indistinguishable from the
+// nameserver's wildcard/sinkhole response for that domain.
+type wildcardFingerprints map[wildcardKey]map[string]bool
+
+// wildcardProbeJob is a single (domain, nameserver, record type) to probe.
+type wildcardProbeJob struct {
+	domain string
+	nsAddr string
+	qtype  uint16
+}
+
+// detectWildcards probes every (domain, nameserver, record type) combination
+// with probeCount randomized non-existent subdomains of domain and records
+// the answer signatures that come back, so the main scan can tag or suppress
+// results that just reflect a catch-all response rather than a real record.
+func detectWildcards(domainsList []string, mappedNameservers map[string]Nameserver, qtypes []uint16, probeCount int, followCNAME bool, maxCNAMEDepth int, opts transportOptions, limiter *queryLimiter, stats *statsRegistry, maxRetries int, numWorkers int) wildcardFingerprints {
+	fingerprints := make(wildcardFingerprints)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	pendingProbes := make(chan wildcardProbeJob)
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range pendingProbes {
+				ns := mappedNameservers[job.nsAddr]
+
+				sigs := make(map[string]bool)
+				for i := 0; i < probeCount; i++ {
+					probeDomain := randomLabel() + "." + job.domain
+					result := resolveChain(probeDomain, ns, job.qtype, opts, followCNAME, maxCNAMEDepth, limiter, stats, maxRetries)
+					if result.Answer == nil {
+						continue
+					}
+					sigs[chainSignature(result)] = true
+				}
+
+				if len(sigs) == 0 {
+					continue
+				}
+
+				key := wildcardKey{domain: job.domain, nsAddr: job.nsAddr, qtype: job.qtype}
+				mu.Lock()
+				fingerprints[key] = sigs
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for nsAddr := range mappedNameservers {
+		if nsAddr == "" {
+			continue
+		}
+
+		for _, domain := range domainsList {
+			for _, qtype := range qtypes {
+				pendingProbes <- wildcardProbeJob{domain: domain, nsAddr: nsAddr, qtype: qtype}
+			}
+		}
+	}
+	close(pendingProbes)
+
+	wg.Wait()
+
+	return fingerprints
+}
+
+// isWildcardMatch reports whether result's answer matches the wildcard
+// fingerprint recorded for (domain, nsAddr, qtype), if any was recorded.
+func (fp wildcardFingerprints) isWildcardMatch(domain, nsAddr string, qtype uint16, result chainResult) bool {
+	if fp == nil || result.Answer == nil {
+		return false
+	}
+
+	sigs, ok := fp[wildcardKey{domain: domain, nsAddr: nsAddr, qtype: qtype}]
+	return ok && sigs[chainSignature(result)]
+}
+
+// isWildcardMatchMsg is isWildcardMatch for callers holding a raw *dns.Msg
+// rather than a chainResult, such as consensus mode's per-nameserver answers.
+func (fp wildcardFingerprints) isWildcardMatchMsg(domain, nsAddr string, qtype uint16, resp *dns.Msg, err error) bool {
+	if fp == nil || err != nil || resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return false
+	}
+
+	sigs, ok := fp[wildcardKey{domain: domain, nsAddr: nsAddr, qtype: qtype}]
+	return ok && sigs[rrSetSignature(resp.Answer)]
+}
+
+// chainSignature reduces a resolved chainResult to the same kind of
+// comparable string answerSignature builds for consensus mode, so a live
+// scan result can be checked against a wildcard fingerprint.
+func chainSignature(result chainResult) string {
+	return rrSetSignature(result.Answer.Answer)
+}
+
+// rrSetSignature reduces a set of answer RRs to a comparable string,
+// ignoring owner name and TTL, shared by consensus grouping and wildcard
+// fingerprint matching so both compare answers the same way.
+func rrSetSignature(rrs []dns.RR) string {
+	values := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		values = append(values, formatAnswer(rr))
+	}
+	sort.Strings(values)
+
+	return strings.Join(values, ",")
+}
+
+// randomLabel returns a random hex DNS label used to build a non-existent
+// subdomain for wildcard probing.
+func randomLabel() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}